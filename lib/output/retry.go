@@ -24,10 +24,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Jeffail/benthos/v3/lib/condition"
 	"github.com/Jeffail/benthos/v3/lib/log"
 	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/response"
@@ -38,6 +40,11 @@ import (
 
 //------------------------------------------------------------------------------
 
+// errCircuitOpen is returned by dispatch when a circuit breaker is rejecting
+// sends, so that the retry loop can recognise the rejection and nack the
+// message immediately instead of retrying it.
+var errCircuitOpen = errors.New("circuit breaker open")
+
 func init() {
 	Constructors[TypeRetry] = TypeSpec{
 		constructor: NewRetry,
@@ -56,7 +63,96 @@ we want to avoid reapplying to the same message more than once in the pipeline.
 
 Rather than retrying the same output you may wish to retry the send using a
 different output target (a dead letter queue). In which case you should instead
-use the ` + "[`try`](#try)" + ` output type.`,
+use the ` + "[`try`](#try)" + ` output type, or the ` + "`dead_letter`" + ` field described below.
+
+### Error Policy
+
+By default every failed send is retried using the configured backoff. The
+` + "`error_policy`" + ` field allows specific errors to be classified and
+routed to a different action instead, so that a permanent failure (such as an
+auth rejection) doesn't burn through the same backoff schedule as a transient
+one. Each rule may match on a regular expression against the error string, a
+condition evaluated against the message, or both, and is mapped to one of the
+actions ` + "`retry`, `drop`, `ack` or `dead_letter`" + `. Rules are evaluated
+in order and the first match wins; a failed send that matches no rule is
+retried as normal.
+
+### In Flight Limits
+
+By default any number of transactions may be outstanding at once, but only
+one failing message at a time may be backing off and resending, so that the
+wrapped output never receives more than one message's worth of retry traffic
+at a time. The ` + "`max_in_flight`" + ` field caps the number of transactions
+that may be outstanding at once, and ` + "`max_retrying`" + ` (independently)
+caps how many of those may be actively backing off and resending at the same
+time, so that one slow or failing message no longer blocks the rest. Both
+fields follow the same convention: ` + "`0`" + ` means unbounded, though
+` + "`max_retrying`" + ` defaults to ` + "`1`" + ` to keep the single retrying
+message behaviour described above unless it is explicitly raised or set to ` + "`0`" + `.
+
+### Dead Letter Output
+
+An optional ` + "`dead_letter`" + ` child output may be configured alongside
+` + "`output`" + `. When the retries of a message are exhausted, or a failed
+send is routed to the ` + "`dead_letter`" + ` action by the error policy above,
+the message is handed to this output instead of being nacked upstream, and is
+only acked once the dead letter send succeeds. This merges the behaviour of
+the ` + "[`try`](#try)" + ` output into ` + "`retry`" + `, so a dead letter
+queue (for example a Kafka DLQ topic) no longer requires a separate ` + "`try`" + `
+wrapper.
+
+### Hedged Requests
+
+When ` + "`max_hedges`" + ` is greater than zero the Retry output races extra,
+parallel copies of a transaction against the wrapped output instead of
+waiting for a failure before resending. The first copy is dispatched
+immediately, and up to ` + "`max_hedges`" + ` additional copies follow, each
+staggered by ` + "`hedge_delay`" + ` (plus up to ` + "`hedge_jitter`" + ` of
+random jitter) after the last. The first successful response wins and the
+remaining in-flight copies are discarded; a send is only considered failed
+once every copy has failed. Use ` + "`hedge_if`" + ` to opt specific messages
+out of hedging, for example when a send is not idempotent.
+
+### Backoff Strategy
+
+The ` + "`strategy`" + ` field selects how the delay between retries of a
+single message grows. ` + "`exponential`" + ` (the default) is the plain
+` + "`cenkalti/backoff`" + ` exponential schedule. ` + "`full_jitter`" + `
+sleeps a random duration between zero and the capped exponential value,
+` + "`equal_jitter`" + ` sleeps half of that value plus a random amount up to
+the other half, and ` + "`decorrelated_jitter`" + ` sleeps a random duration
+between the base interval and three times the previous sleep (capped). The
+jittered strategies all use the same ` + "`backoff.initial_interval`" + `,
+` + "`backoff.max_interval`" + ` and ` + "`backoff.max_elapsed_time`" + `
+fields as the exponential strategy, and exist to avoid the thundering herd of
+retries that a fixed-multiplier schedule causes when a downstream sink
+recovers after an outage.
+
+### Circuit Breaker
+
+Enabling ` + "`circuit_breaker.enabled`" + ` wraps the wrapped output with a
+circuit breaker that complements the per-message backoff with a system-wide
+safety valve. Once ` + "`circuit_breaker.failure_threshold`" + ` consecutive
+sends fail (or, when ` + "`circuit_breaker.window`" + ` is set, the failure
+ratio over that many of the most recent sends reaches
+` + "`circuit_breaker.failure_ratio`" + `) the breaker trips open and every new
+transaction is nacked immediately for ` + "`circuit_breaker.open_duration`" + `
+without touching the wrapped output at all. After that it admits a single
+probe transaction; a successful probe closes the breaker again, a failed one
+reopens it. This lets upstream sources see backpressure as soon as a sink is
+completely down, rather than every message individually running through its
+whole retry budget.
+
+### Message Metadata
+
+Setting ` + "`annotate_messages`" + ` to ` + "`true`" + ` writes the metadata
+fields ` + "`retry_attempt`" + ` (an integer starting at zero),
+` + "`retry_last_error`" + ` (the error string of the previous attempt, empty
+on the first) and ` + "`retry_first_attempt_unix_ms`" + ` onto every part of a
+message before each send. This lets a processor inside the wrapped output,
+for example a condition guarding a fallback codec or a ` + "`log`" + `
+processor, react to how many times a message has already been attempted. It
+is disabled by default so that existing pipelines see no metadata changes.`,
 		sanitiseConfigFunc: func(conf Config) (interface{}, error) {
 			confBytes, err := json.Marshal(conf.Retry)
 			if err != nil {
@@ -75,6 +171,39 @@ use the ` + "[`try`](#try)" + ` output type.`,
 				}
 			}
 			confMap["output"] = outputSanit
+
+			var deadLetterSanit interface{} = struct{}{}
+			if conf.Retry.DeadLetter != nil {
+				if deadLetterSanit, err = SanitiseConfig(*conf.Retry.DeadLetter); err != nil {
+					return nil, err
+				}
+			}
+			confMap["dead_letter"] = deadLetterSanit
+
+			var hedgeIfSanit interface{} = struct{}{}
+			if conf.Retry.HedgeIf != nil {
+				if hedgeIfSanit, err = condition.SanitiseConfig(*conf.Retry.HedgeIf); err != nil {
+					return nil, err
+				}
+			}
+			confMap["hedge_if"] = hedgeIfSanit
+
+			policySanit := make([]interface{}, len(conf.Retry.ErrorPolicy))
+			for i, rule := range conf.Retry.ErrorPolicy {
+				ruleMap := map[string]interface{}{
+					"pattern": rule.Pattern,
+					"action":  rule.Action,
+				}
+				var condSanit interface{} = struct{}{}
+				if rule.Condition != nil {
+					if condSanit, err = condition.SanitiseConfig(*rule.Condition); err != nil {
+						return nil, err
+					}
+				}
+				ruleMap["condition"] = condSanit
+				policySanit[i] = ruleMap
+			}
+			confMap["error_policy"] = policySanit
 			return confMap, nil
 		},
 	}
@@ -84,8 +213,19 @@ use the ` + "[`try`](#try)" + ` output type.`,
 
 // RetryConfig contains configuration values for the Retry output type.
 type RetryConfig struct {
-	Output         *Config `json:"output" yaml:"output"`
-	retries.Config `json:",inline" yaml:",inline"`
+	Output           *Config              `json:"output" yaml:"output"`
+	DeadLetter       *Config              `json:"dead_letter" yaml:"dead_letter"`
+	ErrorPolicy      []ErrorRuleConfig    `json:"error_policy" yaml:"error_policy"`
+	MaxInFlight      int                  `json:"max_in_flight" yaml:"max_in_flight"`
+	MaxRetrying      int                  `json:"max_retrying" yaml:"max_retrying"`
+	HedgeDelay       string               `json:"hedge_delay" yaml:"hedge_delay"`
+	HedgeJitter      string               `json:"hedge_jitter" yaml:"hedge_jitter"`
+	MaxHedges        int                  `json:"max_hedges" yaml:"max_hedges"`
+	HedgeIf          *condition.Config    `json:"hedge_if" yaml:"hedge_if"`
+	Strategy         string               `json:"strategy" yaml:"strategy"`
+	CircuitBreaker   CircuitBreakerConfig `json:"circuit_breaker" yaml:"circuit_breaker"`
+	AnnotateMessages bool                 `json:"annotate_messages" yaml:"annotate_messages"`
+	retries.Config   `json:",inline" yaml:",inline"`
 }
 
 // NewRetryConfig creates a new RetryConfig with default values.
@@ -96,39 +236,95 @@ func NewRetryConfig() RetryConfig {
 	rConf.Backoff.MaxInterval = "1s"
 	rConf.Backoff.MaxElapsedTime = "0s"
 	return RetryConfig{
-		Output: nil,
-		Config: retries.NewConfig(),
+		Output:           nil,
+		DeadLetter:       nil,
+		ErrorPolicy:      nil,
+		MaxInFlight:      0,
+		MaxRetrying:      1,
+		HedgeDelay:       "50ms",
+		HedgeJitter:      "0s",
+		MaxHedges:        0,
+		HedgeIf:          nil,
+		Strategy:         "exponential",
+		CircuitBreaker:   NewCircuitBreakerConfig(),
+		AnnotateMessages: false,
+		Config:           retries.NewConfig(),
 	}
 }
 
 //------------------------------------------------------------------------------
 
 type dummyRetryConfig struct {
-	Output         interface{} `json:"output" yaml:"output"`
-	retries.Config `json:",inline" yaml:",inline"`
+	Output           interface{}          `json:"output" yaml:"output"`
+	DeadLetter       interface{}          `json:"dead_letter" yaml:"dead_letter"`
+	ErrorPolicy      []ErrorRuleConfig    `json:"error_policy" yaml:"error_policy"`
+	MaxInFlight      int                  `json:"max_in_flight" yaml:"max_in_flight"`
+	MaxRetrying      int                  `json:"max_retrying" yaml:"max_retrying"`
+	HedgeDelay       string               `json:"hedge_delay" yaml:"hedge_delay"`
+	HedgeJitter      string               `json:"hedge_jitter" yaml:"hedge_jitter"`
+	MaxHedges        int                  `json:"max_hedges" yaml:"max_hedges"`
+	HedgeIf          interface{}          `json:"hedge_if" yaml:"hedge_if"`
+	Strategy         string               `json:"strategy" yaml:"strategy"`
+	CircuitBreaker   CircuitBreakerConfig `json:"circuit_breaker" yaml:"circuit_breaker"`
+	AnnotateMessages bool                 `json:"annotate_messages" yaml:"annotate_messages"`
+	retries.Config   `json:",inline" yaml:",inline"`
 }
 
 // MarshalJSON prints an empty object instead of nil.
 func (r RetryConfig) MarshalJSON() ([]byte, error) {
 	dummy := dummyRetryConfig{
-		Output: r.Output,
-		Config: r.Config,
+		Output:           r.Output,
+		DeadLetter:       r.DeadLetter,
+		ErrorPolicy:      r.ErrorPolicy,
+		MaxInFlight:      r.MaxInFlight,
+		MaxRetrying:      r.MaxRetrying,
+		HedgeDelay:       r.HedgeDelay,
+		HedgeJitter:      r.HedgeJitter,
+		MaxHedges:        r.MaxHedges,
+		HedgeIf:          r.HedgeIf,
+		Strategy:         r.Strategy,
+		CircuitBreaker:   r.CircuitBreaker,
+		AnnotateMessages: r.AnnotateMessages,
+		Config:           r.Config,
 	}
 	if r.Output == nil {
 		dummy.Output = struct{}{}
 	}
+	if r.DeadLetter == nil {
+		dummy.DeadLetter = struct{}{}
+	}
+	if r.HedgeIf == nil {
+		dummy.HedgeIf = struct{}{}
+	}
 	return json.Marshal(dummy)
 }
 
 // MarshalYAML prints an empty object instead of nil.
 func (r RetryConfig) MarshalYAML() (interface{}, error) {
 	dummy := dummyRetryConfig{
-		Output: r.Output,
-		Config: r.Config,
+		Output:           r.Output,
+		DeadLetter:       r.DeadLetter,
+		ErrorPolicy:      r.ErrorPolicy,
+		MaxInFlight:      r.MaxInFlight,
+		MaxRetrying:      r.MaxRetrying,
+		HedgeDelay:       r.HedgeDelay,
+		HedgeJitter:      r.HedgeJitter,
+		MaxHedges:        r.MaxHedges,
+		HedgeIf:          r.HedgeIf,
+		Strategy:         r.Strategy,
+		CircuitBreaker:   r.CircuitBreaker,
+		AnnotateMessages: r.AnnotateMessages,
+		Config:           r.Config,
 	}
 	if r.Output == nil {
 		dummy.Output = struct{}{}
 	}
+	if r.DeadLetter == nil {
+		dummy.DeadLetter = struct{}{}
+	}
+	if r.HedgeIf == nil {
+		dummy.HedgeIf = struct{}{}
+	}
 	return dummy, nil
 }
 
@@ -141,13 +337,24 @@ type Retry struct {
 	conf    RetryConfig
 
 	wrapped     Type
+	deadLetter  Type
 	backoffCtor func() backoff.BackOff
+	policy      *errorPolicy
+	hedging     *hedgePolicy
+	breaker     *circuitBreaker
+
+	// inFlightSem bounds the number of transactions outstanding at once, and
+	// retryingSem bounds how many of those may be actively backing off and
+	// resending at the same time. Both are nil when unbounded.
+	inFlightSem chan struct{}
+	retryingSem chan struct{}
 
 	stats metrics.Type
 	log   log.Modular
 
 	transactionsIn  <-chan types.Transaction
 	transactionsOut chan types.Transaction
+	deadLetterOut   chan types.Transaction
 
 	closeChan  chan struct{}
 	closedChan chan struct{}
@@ -169,9 +376,60 @@ func NewRetry(
 		return nil, fmt.Errorf("failed to create output '%v': %v", conf.Retry.Output.Type, err)
 	}
 
+	var deadLetter Type
+	if conf.Retry.DeadLetter != nil {
+		if deadLetter, err = New(*conf.Retry.DeadLetter, mgr, log, stats); err != nil {
+			return nil, fmt.Errorf("failed to create dead_letter output '%v': %v", conf.Retry.DeadLetter.Type, err)
+		}
+	}
+
 	var boffCtor func() backoff.BackOff
-	if boffCtor, err = conf.Retry.GetCtor(); err != nil {
-		return nil, err
+	switch conf.Retry.Strategy {
+	case "", "exponential":
+		if boffCtor, err = conf.Retry.GetCtor(); err != nil {
+			return nil, err
+		}
+	case "full_jitter", "equal_jitter", "decorrelated_jitter":
+		if boffCtor, err = newJitterBackoffCtor(conf.Retry); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unrecognised strategy: %v", conf.Retry.Strategy)
+	}
+
+	var policy *errorPolicy
+	if len(conf.Retry.ErrorPolicy) > 0 {
+		if policy, err = newErrorPolicy(conf.Retry.ErrorPolicy, mgr, log, stats); err != nil {
+			return nil, fmt.Errorf("failed to create error policy: %v", err)
+		}
+	}
+
+	var inFlightSem chan struct{}
+	if conf.Retry.MaxInFlight > 0 {
+		inFlightSem = make(chan struct{}, conf.Retry.MaxInFlight)
+	}
+	var retryingSem chan struct{}
+	if conf.Retry.MaxRetrying > 0 {
+		retryingSem = make(chan struct{}, conf.Retry.MaxRetrying)
+	}
+
+	var deadLetterOut chan types.Transaction
+	if deadLetter != nil {
+		deadLetterOut = make(chan types.Transaction)
+	}
+
+	var hedging *hedgePolicy
+	if conf.Retry.MaxHedges > 0 {
+		if hedging, err = newHedgePolicy(conf.Retry, mgr, log, stats); err != nil {
+			return nil, fmt.Errorf("failed to create hedge policy: %v", err)
+		}
+	}
+
+	var breaker *circuitBreaker
+	if conf.Retry.CircuitBreaker.Enabled {
+		if breaker, err = newCircuitBreaker(conf.Retry.CircuitBreaker, stats); err != nil {
+			return nil, fmt.Errorf("failed to create circuit breaker: %v", err)
+		}
 	}
 
 	return &Retry{
@@ -181,25 +439,119 @@ func NewRetry(
 		log:             log,
 		stats:           stats,
 		wrapped:         wrapped,
+		deadLetter:      deadLetter,
 		backoffCtor:     boffCtor,
+		policy:          policy,
+		hedging:         hedging,
+		breaker:         breaker,
+		inFlightSem:     inFlightSem,
+		retryingSem:     retryingSem,
 		transactionsOut: make(chan types.Transaction),
+		deadLetterOut:   deadLetterOut,
 
 		closeChan:  make(chan struct{}),
 		closedChan: make(chan struct{}),
 	}, nil
 }
 
+// sendToDeadLetter hands payload to the configured dead_letter output and
+// blocks for its response. The second return value is false if the Retry
+// output is shutting down before a response was received.
+func (r *Retry) sendToDeadLetter(payload types.Message) (types.Response, bool) {
+	resChan := make(chan types.Response)
+	select {
+	case r.deadLetterOut <- types.NewTransaction(payload, resChan):
+	case <-r.closeChan:
+		return nil, false
+	}
+	select {
+	case res := <-resChan:
+		return res, true
+	case <-r.closeChan:
+		return nil, false
+	}
+}
+
+// dispatchOnce sends a single copy of payload to the wrapped output and
+// blocks for its response. A nil response indicates the output is shutting
+// down.
+func (r *Retry) dispatchOnce(payload types.Message) types.Response {
+	resChan := make(chan types.Response)
+	select {
+	case r.transactionsOut <- types.NewTransaction(payload, resChan):
+	case <-r.closeChan:
+		return nil
+	}
+	select {
+	case res := <-resChan:
+		return res
+	case <-r.closeChan:
+		return nil
+	}
+}
+
+// dispatch sends payload to the wrapped output, hedging it across multiple
+// parallel copies when a hedge policy is configured and opts this message in.
+// A nil response indicates the output is shutting down. When a circuit
+// breaker is configured and open the send is rejected without touching the
+// wrapped output at all.
+func (r *Retry) dispatch(payload types.Message) types.Response {
+	var isProbe bool
+	if r.breaker != nil {
+		if !r.breaker.allow() {
+			r.log.Warnln("Circuit breaker open, rejecting send")
+			return response.NewError(errCircuitOpen)
+		}
+		isProbe = r.breaker.isProbe()
+	}
+
+	var res types.Response
+	if !isProbe && r.hedging != nil && r.hedging.wants(payload) {
+		res = r.hedging.dispatch(r, payload)
+	} else {
+		res = r.dispatchOnce(payload)
+	}
+
+	if r.breaker != nil && res != nil {
+		r.breaker.onResult(res.Error())
+	}
+	return res
+}
+
+// annotateRetry writes the retry_attempt, retry_last_error and
+// retry_first_attempt_unix_ms metadata fields onto every part of payload so
+// that processors in the wrapped output can react to retry state.
+func annotateRetry(payload types.Message, attempt int, firstAttempt time.Time, lastErr error) {
+	lastErrStr := ""
+	if lastErr != nil {
+		lastErrStr = lastErr.Error()
+	}
+	firstAttemptMS := strconv.FormatInt(firstAttempt.UnixNano()/int64(time.Millisecond), 10)
+	payload.Iter(func(i int, p types.Part) error {
+		p.Metadata().Set("retry_attempt", strconv.Itoa(attempt))
+		p.Metadata().Set("retry_last_error", lastErrStr)
+		p.Metadata().Set("retry_first_attempt_unix_ms", firstAttemptMS)
+		return nil
+	})
+}
+
 //------------------------------------------------------------------------------
 
 func (r *Retry) loop() {
 	// Metrics paths
 	var (
-		mRunning      = r.stats.GetGauge("retry.running")
-		mCount        = r.stats.GetCounter("retry.count")
-		mSuccess      = r.stats.GetCounter("retry.send.success")
-		mPartsSuccess = r.stats.GetCounter("retry.parts.send.success")
-		mError        = r.stats.GetCounter("retry.send.error")
-		mEndOfRetries = r.stats.GetCounter("retry.end_of_retries")
+		mRunning       = r.stats.GetGauge("retry.running")
+		mCount         = r.stats.GetCounter("retry.count")
+		mSuccess       = r.stats.GetCounter("retry.send.success")
+		mPartsSuccess  = r.stats.GetCounter("retry.parts.send.success")
+		mError         = r.stats.GetCounter("retry.send.error")
+		mEndOfRetries  = r.stats.GetCounter("retry.end_of_retries")
+		mPolicyDrop    = r.stats.GetCounter("retry.policy.drop")
+		mPolicyAck     = r.stats.GetCounter("retry.policy.ack")
+		mPolicyDead    = r.stats.GetCounter("retry.policy.dead_letter")
+		mDeadLetterOK  = r.stats.GetCounter("retry.dead_letter.send.success")
+		mDeadLetterErr = r.stats.GetCounter("retry.dead_letter.send.error")
+		mCircuitReject = r.stats.GetCounter("retry.circuit.rejected")
 	)
 
 	defer func() {
@@ -208,23 +560,27 @@ func (r *Retry) loop() {
 		err := r.wrapped.WaitForClose(time.Second)
 		for ; err != nil; err = r.wrapped.WaitForClose(time.Second) {
 		}
+		if r.deadLetter != nil {
+			close(r.deadLetterOut)
+			r.deadLetter.CloseAsync()
+			dlErr := r.deadLetter.WaitForClose(time.Second)
+			for ; dlErr != nil; dlErr = r.deadLetter.WaitForClose(time.Second) {
+			}
+		}
 		mRunning.Decr(1)
 		close(r.closedChan)
 	}()
 	mRunning.Incr(1)
 
 	wg := sync.WaitGroup{}
-	errInterruptChan := make(chan struct{})
-	var errLooped int64
 
 	for atomic.LoadInt32(&r.running) == 1 {
-		// Do not consume another message while pending messages are being
-		// reattempted.
-		for atomic.LoadInt64(&errLooped) > 0 {
+		// Bound the number of transactions outstanding at once, blocking
+		// until a slot frees up rather than pausing consumption entirely the
+		// moment any single message starts retrying.
+		if r.inFlightSem != nil {
 			select {
-			case <-errInterruptChan:
-			case <-time.After(time.Millisecond * 100):
-				// Just incase an interrupt doesn't arrive.
+			case r.inFlightSem <- struct{}{}:
 			case <-r.closeChan:
 				return
 			}
@@ -242,50 +598,101 @@ func (r *Retry) loop() {
 			return
 		}
 
-		rChan := make(chan types.Response)
-		select {
-		case r.transactionsOut <- types.NewTransaction(tran.Payload, rChan):
-		case <-r.closeChan:
-			return
-		}
-
 		wg.Add(1)
-		go func(ts types.Transaction, resChan chan types.Response) {
+		go func(ts types.Transaction) {
 			var backOff backoff.BackOff
 			var resOut types.Response
-			var inErrLoop bool
+			var inRetryingSem bool
+			var attempt int
+			var firstAttempt time.Time
+			var lastErr error
 
 			defer func() {
 				wg.Done()
-				if inErrLoop {
-					atomic.AddInt64(&errLooped, -1)
-
-					// We're exiting our error loop, so (attempt to) interrupt the
-					// consumer.
-					select {
-					case errInterruptChan <- struct{}{}:
-					default:
-					}
+				if r.inFlightSem != nil {
+					<-r.inFlightSem
+				}
+				if inRetryingSem {
+					<-r.retryingSem
 				}
 			}()
 
 		retryLoop:
 			for atomic.LoadInt32(&r.running) == 1 {
-				var res types.Response
-				select {
-				case res = <-resChan:
-				case <-r.closeChan:
+				if r.conf.AnnotateMessages {
+					if attempt == 0 {
+						firstAttempt = time.Now()
+					}
+					annotateRetry(ts.Payload, attempt, firstAttempt, lastErr)
+				}
+
+				res := r.dispatch(ts.Payload)
+				if res == nil {
 					return
 				}
 
-				if res.Error() != nil {
-					if !inErrLoop {
-						inErrLoop = true
-						atomic.AddInt64(&errLooped, 1)
-					}
+				// A circuit breaker rejection is not a send attempt against
+				// the wrapped output, so resolve it immediately as a nack
+				// rather than burning this message's own backoff schedule.
+				if res.Error() == errCircuitOpen {
+					mCircuitReject.Incr(1)
+					resOut = response.NewNoack()
+					break retryLoop
+				}
+				attempt++
 
+				if res.Error() != nil {
 					mError.Incr(1)
 					r.log.Errorf("Failed to send message: %v\n", res.Error())
+					lastErr = res.Error()
+
+					action := ErrorActionRetry
+					if r.policy != nil {
+						action = r.policy.classify(res.Error(), ts.Payload)
+					}
+
+					switch action {
+					case ErrorActionDrop:
+						mPolicyDrop.Incr(1)
+						r.log.Warnf("Dropping message after send error matched a drop rule: %v\n", res.Error())
+						resOut = response.NewAck()
+						break retryLoop
+					case ErrorActionAck:
+						mPolicyAck.Incr(1)
+						r.log.Warnf("Acking message after send error matched an ack rule: %v\n", res.Error())
+						resOut = response.NewAck()
+						break retryLoop
+					case ErrorActionDeadLetter:
+						mPolicyDead.Incr(1)
+						if r.deadLetter == nil {
+							r.log.Warnf("Send error matched a dead_letter rule but no dead_letter output is configured, nacking instead: %v\n", res.Error())
+							resOut = response.NewNoack()
+							break retryLoop
+						}
+						dlqRes, ok := r.sendToDeadLetter(ts.Payload)
+						if !ok {
+							return
+						}
+						if dlqRes.Error() != nil {
+							mDeadLetterErr.Incr(1)
+							r.log.Errorf("Failed to send message to dead_letter output: %v\n", dlqRes.Error())
+							resOut = response.NewNoack()
+						} else {
+							mDeadLetterOK.Incr(1)
+							resOut = response.NewAck()
+						}
+						break retryLoop
+					}
+
+					if r.retryingSem != nil && !inRetryingSem {
+						select {
+						case r.retryingSem <- struct{}{}:
+							inRetryingSem = true
+						case <-r.closeChan:
+							return
+						}
+					}
+
 					if backOff == nil {
 						backOff = r.backoffCtor()
 					}
@@ -293,7 +700,22 @@ func (r *Retry) loop() {
 					nextBackoff := backOff.NextBackOff()
 					if nextBackoff == backoff.Stop {
 						mEndOfRetries.Incr(1)
-						resOut = response.NewNoack()
+						if r.deadLetter == nil {
+							resOut = response.NewNoack()
+							break retryLoop
+						}
+						dlqRes, ok := r.sendToDeadLetter(ts.Payload)
+						if !ok {
+							return
+						}
+						if dlqRes.Error() != nil {
+							mDeadLetterErr.Incr(1)
+							r.log.Errorf("Failed to send message to dead_letter output: %v\n", dlqRes.Error())
+							resOut = response.NewNoack()
+						} else {
+							mDeadLetterOK.Incr(1)
+							resOut = response.NewAck()
+						}
 						break retryLoop
 					}
 					select {
@@ -301,12 +723,6 @@ func (r *Retry) loop() {
 					case <-r.closeChan:
 						return
 					}
-
-					select {
-					case r.transactionsOut <- types.NewTransaction(ts.Payload, resChan):
-					case <-r.closeChan:
-						return
-					}
 				} else {
 					mSuccess.Incr(1)
 					mPartsSuccess.Incr(int64(ts.Payload.Len()))
@@ -320,7 +736,7 @@ func (r *Retry) loop() {
 			case <-r.closeChan:
 				return
 			}
-		}(tran, rChan)
+		}(tran)
 	}
 
 	wg.Wait()
@@ -334,6 +750,11 @@ func (r *Retry) Consume(ts <-chan types.Transaction) error {
 	if err := r.wrapped.Consume(r.transactionsOut); err != nil {
 		return err
 	}
+	if r.deadLetter != nil {
+		if err := r.deadLetter.Consume(r.deadLetterOut); err != nil {
+			return err
+		}
+	}
 	r.transactionsIn = ts
 	go r.loop()
 	return nil
@@ -342,6 +763,9 @@ func (r *Retry) Consume(ts <-chan types.Transaction) error {
 // Connected returns a boolean indicating whether this output is currently
 // connected to its target.
 func (r *Retry) Connected() bool {
+	if r.deadLetter != nil && !r.deadLetter.Connected() {
+		return false
+	}
 	return r.wrapped.Connected()
 }
 
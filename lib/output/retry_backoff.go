@@ -0,0 +1,165 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+//------------------------------------------------------------------------------
+
+// jitterBackOff implements backoff.BackOff with one of the full_jitter,
+// equal_jitter or decorrelated_jitter strategies described in
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/,
+// reusing the same initial_interval/max_interval/max_elapsed_time fields as the
+// plain exponential strategy.
+type jitterBackOff struct {
+	strategy   string
+	base       time.Duration
+	capAt      time.Duration
+	maxElapsed time.Duration
+
+	start time.Time
+	// curExp is the exponential value due on the next call, doubling once per
+	// attempt starting from base. It is clamped to capAt (when set) or frozen
+	// just short of overflowing a time.Duration, so it can never wrap
+	// negative no matter how many attempts are made.
+	curExp time.Duration
+	prev   time.Duration
+}
+
+func (j *jitterBackOff) Reset() {
+	j.start = time.Time{}
+	j.curExp = 0
+	j.prev = 0
+}
+
+func (j *jitterBackOff) NextBackOff() time.Duration {
+	if j.start.IsZero() {
+		j.start = time.Now()
+	}
+	if j.maxElapsed > 0 && time.Since(j.start) > j.maxElapsed {
+		return backoff.Stop
+	}
+
+	var next time.Duration
+	switch j.strategy {
+	case "full_jitter":
+		capped := j.nextExp()
+		next = time.Duration(rand.Int63n(int64(capped) + 1))
+	case "equal_jitter":
+		capped := j.nextExp()
+		half := capped / 2
+		next = half + time.Duration(rand.Int63n(int64(half)+1))
+	case "decorrelated_jitter":
+		prev := j.prev
+		if prev < j.base {
+			prev = j.base
+		}
+		maxSleep := prev * 3
+		if maxSleep <= j.base || maxSleep/3 != prev {
+			maxSleep = j.base + 1
+		}
+		next = j.capAtDuration(j.base + time.Duration(rand.Int63n(int64(maxSleep-j.base))))
+		j.prev = next
+	default:
+		return backoff.Stop
+	}
+
+	return next
+}
+
+// nextExp returns the exponential value due on this call (base doubled once
+// per attempt so far, capped at the configured max interval) and advances
+// curExp for the next call. Growth is clamped at capAt, or frozen the moment
+// doubling would overflow a time.Duration, so it never wraps negative
+// regardless of how many attempts are made.
+func (j *jitterBackOff) nextExp() time.Duration {
+	if j.curExp == 0 {
+		j.curExp = j.base
+	}
+	capped := j.capAtDuration(j.curExp)
+
+	doubled := j.curExp * 2
+	if doubled < j.curExp {
+		doubled = j.curExp
+	}
+	if j.capAt > 0 && doubled > j.capAt {
+		doubled = j.capAt
+	}
+	j.curExp = doubled
+
+	return capped
+}
+
+func (j *jitterBackOff) capAtDuration(d time.Duration) time.Duration {
+	if j.capAt > 0 && d > j.capAt {
+		return j.capAt
+	}
+	return d
+}
+
+//------------------------------------------------------------------------------
+
+// newJitterBackoffCtor returns a constructor of fresh jitterBackOff instances
+// configured from conf, one of which is built per in-flight message. When
+// max_retries is set each instance is wrapped with backoff.WithMaxRetries.
+func newJitterBackoffCtor(conf RetryConfig) (func() backoff.BackOff, error) {
+	base, err := time.ParseDuration(conf.Backoff.InitialInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse backoff.initial_interval: %v", err)
+	}
+
+	var capAt time.Duration
+	if conf.Backoff.MaxInterval != "" {
+		if capAt, err = time.ParseDuration(conf.Backoff.MaxInterval); err != nil {
+			return nil, fmt.Errorf("failed to parse backoff.max_interval: %v", err)
+		}
+	}
+
+	var maxElapsed time.Duration
+	if conf.Backoff.MaxElapsedTime != "" {
+		if maxElapsed, err = time.ParseDuration(conf.Backoff.MaxElapsedTime); err != nil {
+			return nil, fmt.Errorf("failed to parse backoff.max_elapsed_time: %v", err)
+		}
+	}
+
+	strategy := conf.Strategy
+	maxRetries := conf.MaxRetries
+	return func() backoff.BackOff {
+		var b backoff.BackOff = &jitterBackOff{
+			strategy:   strategy,
+			base:       base,
+			capAt:      capAt,
+			maxElapsed: maxElapsed,
+		}
+		if maxRetries > 0 {
+			b = backoff.WithMaxRetries(b, uint64(maxRetries))
+		}
+		return b
+	}, nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,147 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+func TestJitterBackOffNeverOverflowsUncapped(t *testing.T) {
+	for _, strategy := range []string{"full_jitter", "equal_jitter", "decorrelated_jitter"} {
+		strategy := strategy
+		t.Run(strategy, func(t *testing.T) {
+			j := &jitterBackOff{strategy: strategy, base: 3 * time.Second}
+			for attempt := 0; attempt < 64; attempt++ {
+				next := j.NextBackOff()
+				if next < 0 {
+					t.Fatalf("attempt %v: NextBackOff returned negative duration %v", attempt, next)
+				}
+			}
+		})
+	}
+}
+
+func TestJitterBackOffRespectsCap(t *testing.T) {
+	for _, strategy := range []string{"full_jitter", "equal_jitter", "decorrelated_jitter"} {
+		strategy := strategy
+		t.Run(strategy, func(t *testing.T) {
+			j := &jitterBackOff{strategy: strategy, base: time.Second, capAt: 5 * time.Second}
+			for attempt := 0; attempt < 64; attempt++ {
+				next := j.NextBackOff()
+				if next < 0 || next > j.capAt {
+					t.Fatalf("attempt %v: NextBackOff returned %v, want within [0, %v]", attempt, next, j.capAt)
+				}
+			}
+		})
+	}
+}
+
+func TestJitterBackOffStopsAfterMaxElapsed(t *testing.T) {
+	j := &jitterBackOff{strategy: "full_jitter", base: time.Millisecond, maxElapsed: time.Nanosecond}
+	j.start = time.Now().Add(-time.Second)
+	if next := j.NextBackOff(); next != backoff.Stop {
+		t.Fatalf("expected backoff.Stop once maxElapsed has passed, got %v", next)
+	}
+}
+
+func TestJitterBackOffUnrecognisedStrategyStops(t *testing.T) {
+	j := &jitterBackOff{strategy: "not_a_real_strategy", base: time.Second}
+	if next := j.NextBackOff(); next != backoff.Stop {
+		t.Fatalf("expected backoff.Stop for an unrecognised strategy, got %v", next)
+	}
+}
+
+func TestJitterBackOffResetRestartsGrowth(t *testing.T) {
+	j := &jitterBackOff{strategy: "full_jitter", base: time.Second, capAt: 4 * time.Second}
+	for i := 0; i < 10; i++ {
+		j.NextBackOff()
+	}
+	if j.curExp <= j.base {
+		t.Fatalf("expected curExp to have grown past base, got %v", j.curExp)
+	}
+	j.Reset()
+	if j.curExp != 0 || !j.start.IsZero() || j.prev != 0 {
+		t.Fatalf("Reset did not clear internal state: %+v", j)
+	}
+}
+
+func TestNewJitterBackoffCtorUsesStrategy(t *testing.T) {
+	conf := NewRetryConfig()
+	conf.Strategy = "equal_jitter"
+	conf.Backoff.InitialInterval = "10ms"
+	conf.Backoff.MaxInterval = "100ms"
+
+	ctor, err := newJitterBackoffCtor(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	boff := ctor()
+	jboff, ok := boff.(*jitterBackOff)
+	if !ok {
+		t.Fatalf("expected *jitterBackOff, got %T", boff)
+	}
+	if jboff.strategy != "equal_jitter" {
+		t.Fatalf("expected strategy equal_jitter, got %v", jboff.strategy)
+	}
+	if jboff.base != 10*time.Millisecond || jboff.capAt != 100*time.Millisecond {
+		t.Fatalf("unexpected base/capAt: %v/%v", jboff.base, jboff.capAt)
+	}
+}
+
+func TestNewJitterBackoffCtorRespectsMaxRetries(t *testing.T) {
+	for _, strategy := range []string{"full_jitter", "equal_jitter", "decorrelated_jitter"} {
+		strategy := strategy
+		t.Run(strategy, func(t *testing.T) {
+			conf := NewRetryConfig()
+			conf.Strategy = strategy
+			conf.Backoff.InitialInterval = "1ms"
+			conf.Backoff.MaxInterval = "2ms"
+			conf.MaxRetries = 2
+
+			ctor, err := newJitterBackoffCtor(conf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			boff := ctor()
+
+			for attempt := 0; attempt < int(conf.MaxRetries); attempt++ {
+				if next := boff.NextBackOff(); next == backoff.Stop {
+					t.Fatalf("attempt %v: expected a backoff duration within max_retries, got Stop", attempt)
+				}
+			}
+			if next := boff.NextBackOff(); next != backoff.Stop {
+				t.Fatalf("expected backoff.Stop once max_retries is exceeded, got %v", next)
+			}
+		})
+	}
+}
+
+func TestNewJitterBackoffCtorBadDuration(t *testing.T) {
+	conf := NewRetryConfig()
+	conf.Backoff.InitialInterval = "not-a-duration"
+	if _, err := newJitterBackoffCtor(conf); err == nil {
+		t.Fatal("expected an error for an unparsable initial_interval")
+	}
+}
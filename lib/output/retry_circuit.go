@@ -0,0 +1,218 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// CircuitBreakerConfig contains configuration values for the Retry output's
+// optional circuit breaker.
+type CircuitBreakerConfig struct {
+	Enabled          bool    `json:"enabled" yaml:"enabled"`
+	FailureThreshold int     `json:"failure_threshold" yaml:"failure_threshold"`
+	Window           int     `json:"window" yaml:"window"`
+	FailureRatio     float64 `json:"failure_ratio" yaml:"failure_ratio"`
+	OpenDuration     string  `json:"open_duration" yaml:"open_duration"`
+}
+
+// NewCircuitBreakerConfig creates a new CircuitBreakerConfig with default
+// values.
+func NewCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Enabled:          false,
+		FailureThreshold: 5,
+		Window:           0,
+		FailureRatio:     0.5,
+		OpenDuration:     "5s",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type circuitState int32
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker is a system-wide safety valve sitting in front of the
+// wrapped output: once sends fail often enough it stops admitting new
+// transactions for a cooldown period rather than letting every message run
+// through its own retry budget against a sink that is known to be down.
+type circuitBreaker struct {
+	failureThreshold int
+	window           int
+	failureRatio     float64
+	openDuration     time.Duration
+
+	mu          sync.Mutex
+	state       circuitState
+	consecFails int
+	results     []bool
+	openedAt    time.Time
+	probing     bool
+
+	mState   metrics.StatGauge
+	mTripped metrics.StatCounter
+	mProbes  metrics.StatCounter
+}
+
+func newCircuitBreaker(conf CircuitBreakerConfig, stats metrics.Type) (*circuitBreaker, error) {
+	openDuration, err := time.ParseDuration(conf.OpenDuration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse circuit_breaker.open_duration: %v", err)
+	}
+	if conf.FailureThreshold <= 0 {
+		return nil, fmt.Errorf("circuit_breaker.failure_threshold must be greater than zero")
+	}
+
+	return &circuitBreaker{
+		failureThreshold: conf.FailureThreshold,
+		window:           conf.Window,
+		failureRatio:     conf.FailureRatio,
+		openDuration:     openDuration,
+
+		mState:   stats.GetGauge("retry.circuit.state"),
+		mTripped: stats.GetCounter("retry.circuit.tripped"),
+		mProbes:  stats.GetCounter("retry.circuit.probes"),
+	}, nil
+}
+
+// allow reports whether a transaction should be admitted to the wrapped
+// output. When the breaker is open and its cooldown has elapsed it admits a
+// single probe and flips to half_open; every other caller is refused.
+func (c *circuitBreaker) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		return false
+	default: // circuitOpen
+		if time.Since(c.openedAt) < c.openDuration {
+			return false
+		}
+		c.state = circuitHalfOpen
+		c.probing = true
+		c.mState.Set(int64(circuitHalfOpen))
+		c.mProbes.Incr(1)
+		return true
+	}
+}
+
+// isProbe reports whether the most recently admitted transaction is the
+// single probe sent while the breaker is half_open, in which case it must not
+// be hedged into multiple concurrent copies.
+func (c *circuitBreaker) isProbe() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probing
+}
+
+// onResult records the outcome of an admitted send and transitions the
+// breaker's state accordingly.
+func (c *circuitBreaker) onResult(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == circuitHalfOpen {
+		c.probing = false
+		if err != nil {
+			c.trip()
+		} else {
+			c.reset()
+		}
+		return
+	}
+
+	if err == nil {
+		c.consecFails = 0
+		c.recordWindow(false)
+	} else {
+		c.consecFails++
+		c.recordWindow(true)
+	}
+
+	if c.window > 0 {
+		if c.windowFailureRatio() >= c.failureRatio && len(c.results) >= c.window {
+			c.trip()
+		}
+		return
+	}
+
+	if c.consecFails >= c.failureThreshold {
+		c.trip()
+	}
+}
+
+// recordWindow appends failed to the rolling window of the most recent
+// results, discarding older entries once it exceeds the configured size.
+func (c *circuitBreaker) recordWindow(failed bool) {
+	if c.window <= 0 {
+		return
+	}
+	c.results = append(c.results, failed)
+	if len(c.results) > c.window {
+		c.results = c.results[len(c.results)-c.window:]
+	}
+}
+
+func (c *circuitBreaker) windowFailureRatio() float64 {
+	if len(c.results) == 0 {
+		return 0
+	}
+	fails := 0
+	for _, failed := range c.results {
+		if failed {
+			fails++
+		}
+	}
+	return float64(fails) / float64(len(c.results))
+}
+
+func (c *circuitBreaker) trip() {
+	c.state = circuitOpen
+	c.openedAt = time.Now()
+	c.consecFails = 0
+	c.results = nil
+	c.mState.Set(int64(circuitOpen))
+	c.mTripped.Incr(1)
+}
+
+func (c *circuitBreaker) reset() {
+	c.state = circuitClosed
+	c.consecFails = 0
+	c.results = nil
+	c.mState.Set(int64(circuitClosed))
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,162 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+func newTestCircuitBreaker(t *testing.T, conf CircuitBreakerConfig) *circuitBreaker {
+	t.Helper()
+	cb, err := newCircuitBreaker(conf, metrics.Noop())
+	if err != nil {
+		t.Fatalf("unexpected error constructing circuit breaker: %v", err)
+	}
+	return cb
+}
+
+func TestCircuitBreakerTripsAfterConsecutiveFailures(t *testing.T) {
+	conf := NewCircuitBreakerConfig()
+	conf.FailureThreshold = 3
+	conf.OpenDuration = "1h"
+	cb := newTestCircuitBreaker(t, conf)
+
+	for i := 0; i < conf.FailureThreshold-1; i++ {
+		if !cb.allow() {
+			t.Fatalf("expected allow() to be true before the threshold is reached")
+		}
+		cb.onResult(errors.New("boom"))
+	}
+	if cb.state != circuitClosed {
+		t.Fatalf("expected breaker to still be closed, got state %v", cb.state)
+	}
+
+	cb.onResult(errors.New("boom"))
+	if cb.state != circuitOpen {
+		t.Fatalf("expected breaker to trip open after %v consecutive failures", conf.FailureThreshold)
+	}
+	if cb.allow() {
+		t.Fatal("expected allow() to be false while open and within open_duration")
+	}
+}
+
+func TestCircuitBreakerTripsOnWindowFailureRatio(t *testing.T) {
+	conf := NewCircuitBreakerConfig()
+	conf.FailureThreshold = 100
+	conf.Window = 4
+	conf.FailureRatio = 0.5
+	conf.OpenDuration = "1h"
+	cb := newTestCircuitBreaker(t, conf)
+
+	results := []bool{true, true, false, false}
+	for _, failed := range results {
+		cb.allow()
+		var err error
+		if failed {
+			err = errors.New("boom")
+		}
+		cb.onResult(err)
+	}
+	if cb.state != circuitOpen {
+		t.Fatalf("expected breaker to trip once the rolling window hit the failure ratio, got state %v", cb.state)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsConsecutiveFailures(t *testing.T) {
+	conf := NewCircuitBreakerConfig()
+	conf.FailureThreshold = 2
+	conf.OpenDuration = "1h"
+	cb := newTestCircuitBreaker(t, conf)
+
+	cb.allow()
+	cb.onResult(errors.New("boom"))
+	cb.allow()
+	cb.onResult(nil)
+	cb.allow()
+	cb.onResult(errors.New("boom"))
+
+	if cb.state != circuitClosed {
+		t.Fatalf("expected an intervening success to reset the consecutive failure count, got state %v", cb.state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbeSucceedsCloses(t *testing.T) {
+	conf := NewCircuitBreakerConfig()
+	conf.FailureThreshold = 1
+	conf.OpenDuration = "1ms"
+	cb := newTestCircuitBreaker(t, conf)
+
+	cb.allow()
+	cb.onResult(errors.New("boom"))
+	if cb.state != circuitOpen {
+		t.Fatalf("expected breaker to be open, got %v", cb.state)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if !cb.allow() {
+		t.Fatal("expected the cooldown to have elapsed, admitting a single probe")
+	}
+	if cb.state != circuitHalfOpen {
+		t.Fatalf("expected breaker to be half_open after admitting a probe, got %v", cb.state)
+	}
+	if !cb.isProbe() {
+		t.Fatal("expected isProbe() to report true for the admitted probe")
+	}
+	if cb.allow() {
+		t.Fatal("expected no further callers to be admitted while a probe is in flight")
+	}
+
+	cb.onResult(nil)
+	if cb.state != circuitClosed {
+		t.Fatalf("expected a successful probe to close the breaker, got state %v", cb.state)
+	}
+	if cb.isProbe() {
+		t.Fatal("expected isProbe() to report false once the probe has resolved")
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbeFailsReopens(t *testing.T) {
+	conf := NewCircuitBreakerConfig()
+	conf.FailureThreshold = 1
+	conf.OpenDuration = "1ms"
+	cb := newTestCircuitBreaker(t, conf)
+
+	cb.allow()
+	cb.onResult(errors.New("boom"))
+	time.Sleep(5 * time.Millisecond)
+
+	if !cb.allow() {
+		t.Fatal("expected the cooldown to have elapsed, admitting a single probe")
+	}
+
+	cb.onResult(errors.New("still broken"))
+	if cb.state != circuitOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got state %v", cb.state)
+	}
+	if cb.allow() {
+		t.Fatal("expected allow() to be false immediately after reopening")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/response"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+func TestSendToDeadLetterReturnsWrappedResponse(t *testing.T) {
+	r := &Retry{
+		deadLetterOut: make(chan types.Transaction),
+		closeChan:     make(chan struct{}),
+	}
+
+	go func() {
+		tran := <-r.deadLetterOut
+		tran.ResponseChan <- response.NewAck()
+	}()
+
+	payload := message.New([][]byte{[]byte("hello")})
+	res, open := r.sendToDeadLetter(payload)
+	if !open {
+		t.Fatal("expected sendToDeadLetter to report open, not shutting down")
+	}
+	if res == nil || res.Error() != nil {
+		t.Fatalf("expected the dead_letter output's response to be returned unchanged, got %v", res)
+	}
+}
+
+func TestSendToDeadLetterReturnsOnCloseBeforeSend(t *testing.T) {
+	r := &Retry{
+		deadLetterOut: make(chan types.Transaction),
+		closeChan:     make(chan struct{}),
+	}
+	close(r.closeChan)
+
+	payload := message.New([][]byte{[]byte("hello")})
+	res, open := r.sendToDeadLetter(payload)
+	if open {
+		t.Fatal("expected sendToDeadLetter to report shutting down")
+	}
+	if res != nil {
+		t.Fatalf("expected a nil response when closing, got %v", res)
+	}
+}
+
+func TestSendToDeadLetterReturnsOnCloseWhileAwaitingResponse(t *testing.T) {
+	r := &Retry{
+		deadLetterOut: make(chan types.Transaction),
+		closeChan:     make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		<-r.deadLetterOut
+		close(done)
+	}()
+
+	go func() {
+		<-done
+		close(r.closeChan)
+	}()
+
+	payload := message.New([][]byte{[]byte("hello")})
+	resCh := make(chan types.Response, 1)
+	openCh := make(chan bool, 1)
+	go func() {
+		res, open := r.sendToDeadLetter(payload)
+		resCh <- res
+		openCh <- open
+	}()
+
+	select {
+	case open := <-openCh:
+		if open {
+			t.Fatal("expected sendToDeadLetter to report shutting down")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for sendToDeadLetter to return after close")
+	}
+	if res := <-resCh; res != nil {
+		t.Fatalf("expected a nil response when closing, got %v", res)
+	}
+}
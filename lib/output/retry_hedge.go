@@ -0,0 +1,168 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/condition"
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// hedgePolicy races extra, parallel copies of a transaction against the
+// wrapped output rather than waiting for a failure before resending.
+type hedgePolicy struct {
+	delay     time.Duration
+	jitter    time.Duration
+	maxHedges int
+	hedgeIf   types.Condition
+
+	mLaunched metrics.StatCounter
+	mWon      metrics.StatCounter
+	mWasted   metrics.StatCounter
+}
+
+func newHedgePolicy(
+	conf RetryConfig,
+	mgr types.Manager,
+	log log.Modular,
+	stats metrics.Type,
+) (*hedgePolicy, error) {
+	delay, err := time.ParseDuration(conf.HedgeDelay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse hedge_delay: %v", err)
+	}
+
+	var jitter time.Duration
+	if conf.HedgeJitter != "" {
+		if jitter, err = time.ParseDuration(conf.HedgeJitter); err != nil {
+			return nil, fmt.Errorf("failed to parse hedge_jitter: %v", err)
+		}
+	}
+
+	var hedgeIf types.Condition
+	if conf.HedgeIf != nil {
+		if hedgeIf, err = condition.New(*conf.HedgeIf, mgr, log.NewModule(".hedge_if"), metrics.Namespaced(stats, "hedge_if")); err != nil {
+			return nil, fmt.Errorf("failed to create hedge_if condition: %v", err)
+		}
+	}
+
+	return &hedgePolicy{
+		delay:     delay,
+		jitter:    jitter,
+		maxHedges: conf.MaxHedges,
+		hedgeIf:   hedgeIf,
+		mLaunched: stats.GetCounter("retry.hedge.launched"),
+		mWon:      stats.GetCounter("retry.hedge.won"),
+		mWasted:   stats.GetCounter("retry.hedge.wasted"),
+	}, nil
+}
+
+// wants returns true if payload should be hedged, taking the hedge_if guard
+// into account.
+func (h *hedgePolicy) wants(payload types.Message) bool {
+	return h.hedgeIf == nil || h.hedgeIf.Check(payload)
+}
+
+// dispatch fans payload out across up to maxHedges+1 parallel copies of a
+// transaction sent to r.transactionsOut, staggered by delay (plus jitter).
+// The first successful response wins and the remaining copies are discarded;
+// a nil response indicates the output is shutting down, and a failure is only
+// returned once every copy has failed.
+func (h *hedgePolicy) dispatch(r *Retry, payload types.Message) types.Response {
+	total := h.maxHedges + 1
+	results := make(chan types.Response, total)
+	done := make(chan struct{})
+
+	launch := func() {
+		go func() {
+			// Buffered so that the wrapped output's send of its response
+			// never blocks on us, even if this copy has already lost the
+			// race and nothing is left reading from results.
+			resChan := make(chan types.Response, 1)
+			select {
+			case r.transactionsOut <- types.NewTransaction(payload.Copy(), resChan):
+			case <-done:
+				return
+			case <-r.closeChan:
+				return
+			}
+			select {
+			case res := <-resChan:
+				select {
+				case results <- res:
+				case <-done:
+				}
+			case <-done:
+			case <-r.closeChan:
+			}
+		}()
+	}
+
+	launch()
+	go func() {
+		for n := 1; n < total; n++ {
+			wait := h.delay
+			if h.jitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(h.jitter)))
+			}
+			select {
+			case <-time.After(wait):
+			case <-done:
+				return
+			case <-r.closeChan:
+				return
+			}
+			h.mLaunched.Incr(1)
+			launch()
+		}
+	}()
+
+	var lastResult types.Response
+	for received := 0; received < total; received++ {
+		select {
+		case res := <-results:
+			if res.Error() == nil {
+				close(done)
+				h.mWon.Incr(1)
+				if wasted := int64(total - received - 1); wasted > 0 {
+					h.mWasted.Incr(wasted)
+				}
+				return res
+			}
+			lastResult = res
+		case <-r.closeChan:
+			close(done)
+			return nil
+		}
+	}
+
+	close(done)
+	return lastResult
+}
+
+//------------------------------------------------------------------------------
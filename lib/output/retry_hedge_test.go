@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/response"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+// TestHedgeDispatchDrainsLosingResponse is a regression test for a deadlock
+// where a losing hedge copy abandoned its resChan the moment the race was
+// decided, without draining it, wedging the wrapped output's own processing
+// loop forever on a blocking send with no reader.
+func TestHedgeDispatchDrainsLosingResponse(t *testing.T) {
+	r := &Retry{
+		transactionsOut: make(chan types.Transaction),
+		closeChan:       make(chan struct{}),
+	}
+	stats := metrics.Noop()
+	h := &hedgePolicy{
+		delay:     0,
+		maxHedges: 1,
+		mLaunched: stats.GetCounter("launched"),
+		mWon:      stats.GetCounter("won"),
+		mWasted:   stats.GetCounter("wasted"),
+	}
+
+	total := h.maxHedges + 1
+	sendCompleted := make(chan bool, total)
+
+	// Two independent workers race to pick up the two hedge copies off
+	// transactionsOut, exactly as two concurrent attempts against a wrapped
+	// output would. The winner waits until both copies have been picked up,
+	// so that the second copy is always launched; the other is made to
+	// respond only once the race has already been decided, so its send
+	// targets a resChan that nothing is reading from any more.
+	var picked sync.WaitGroup
+	picked.Add(total)
+	for worker := 0; worker < total; worker++ {
+		worker := worker
+		go func() {
+			tran := <-r.transactionsOut
+			picked.Done()
+			var res types.Response
+			if worker == 0 {
+				picked.Wait()
+				res = response.NewAck()
+			} else {
+				time.Sleep(50 * time.Millisecond)
+				res = response.NewError(errors.New("simulated failure"))
+			}
+			select {
+			case tran.ResponseChan <- res:
+				sendCompleted <- true
+			case <-r.closeChan:
+				sendCompleted <- false
+			}
+		}()
+	}
+
+	payload := message.New([][]byte{[]byte("hello")})
+
+	res := h.dispatch(r, payload)
+	if res == nil || res.Error() != nil {
+		t.Fatalf("expected a successful response, got %v", res)
+	}
+
+	for i := 0; i < total; i++ {
+		select {
+		case ok := <-sendCompleted:
+			if !ok {
+				t.Fatalf("a hedge copy's response send did not complete normally")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for a hedge copy's response send to complete; the losing copy's resChan is not being drained")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+// newTestRetryConfig returns a RetryConfig wrapping a minimal child output,
+// suitable for exercising NewRetry's own construction logic.
+func newTestRetryConfig() Config {
+	conf := NewConfig()
+	conf.Type = TypeRetry
+	childConf := NewConfig()
+	childConf.Type = TypeDrop
+	conf.Retry.Output = &childConf
+	return conf
+}
+
+func TestNewRetryMaxRetryingZeroIsUnbounded(t *testing.T) {
+	conf := newTestRetryConfig()
+	conf.Retry.MaxRetrying = 0
+	conf.Retry.MaxInFlight = 0
+
+	out, err := NewRetry(conf, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, ok := out.(*Retry)
+	if !ok {
+		t.Fatalf("expected *Retry, got %T", out)
+	}
+	if r.retryingSem != nil {
+		t.Fatal("expected retryingSem to be nil (unbounded) when max_retrying is 0")
+	}
+	if r.inFlightSem != nil {
+		t.Fatal("expected inFlightSem to be nil (unbounded) when max_in_flight is 0")
+	}
+}
+
+func TestNewRetryMaxRetryingPositiveBoundsSem(t *testing.T) {
+	conf := newTestRetryConfig()
+	conf.Retry.MaxRetrying = 3
+	conf.Retry.MaxInFlight = 7
+
+	out, err := NewRetry(conf, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, ok := out.(*Retry)
+	if !ok {
+		t.Fatalf("expected *Retry, got %T", out)
+	}
+	if cap(r.retryingSem) != 3 {
+		t.Fatalf("expected retryingSem capacity 3, got %v", cap(r.retryingSem))
+	}
+	if cap(r.inFlightSem) != 7 {
+		t.Fatalf("expected inFlightSem capacity 7, got %v", cap(r.inFlightSem))
+	}
+}
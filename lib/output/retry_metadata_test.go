@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+)
+
+func TestAnnotateRetrySetsMetadataOnEveryPart(t *testing.T) {
+	payload := message.New([][]byte{[]byte("one"), []byte("two")})
+	firstAttempt := time.Now().Add(-time.Second)
+	lastErr := errors.New("connection reset")
+
+	annotateRetry(payload, 2, firstAttempt, lastErr)
+
+	wantMS := strconv.FormatInt(firstAttempt.UnixNano()/int64(time.Millisecond), 10)
+	for i := 0; i < payload.Len(); i++ {
+		part := payload.Get(i)
+		if got := part.Metadata().Get("retry_attempt"); got != "2" {
+			t.Errorf("part %v: retry_attempt = %q, want %q", i, got, "2")
+		}
+		if got := part.Metadata().Get("retry_last_error"); got != lastErr.Error() {
+			t.Errorf("part %v: retry_last_error = %q, want %q", i, got, lastErr.Error())
+		}
+		if got := part.Metadata().Get("retry_first_attempt_unix_ms"); got != wantMS {
+			t.Errorf("part %v: retry_first_attempt_unix_ms = %q, want %q", i, got, wantMS)
+		}
+	}
+}
+
+func TestAnnotateRetryBlanksLastErrorWhenNil(t *testing.T) {
+	payload := message.New([][]byte{[]byte("one")})
+	annotateRetry(payload, 0, time.Now(), nil)
+
+	if got := payload.Get(0).Metadata().Get("retry_last_error"); got != "" {
+		t.Errorf("retry_last_error = %q, want empty string for a nil error", got)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Jeffail/benthos/v3/lib/condition"
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// ErrorAction determines how the Retry output should handle a failed send
+// once it has been matched by an ErrorRuleConfig.
+type ErrorAction string
+
+// ErrorAction variants.
+const (
+	// ErrorActionRetry keeps the existing backoff-and-resend behaviour.
+	ErrorActionRetry ErrorAction = "retry"
+	// ErrorActionDrop acks the message upstream without sending it again,
+	// discarding it.
+	ErrorActionDrop ErrorAction = "drop"
+	// ErrorActionAck acks the message upstream without sending it again. It
+	// differs from ErrorActionDrop only in the metric and log line recorded.
+	ErrorActionAck ErrorAction = "ack"
+	// ErrorActionDeadLetter hands the message to the configured dead_letter
+	// output instead of retrying it.
+	ErrorActionDeadLetter ErrorAction = "dead_letter"
+)
+
+// ErrorRuleConfig describes a single matcher and the action to take when a
+// failed send matches it. Rules are evaluated in the order they are
+// configured and the first match wins. A rule with no pattern and no
+// condition matches every error.
+type ErrorRuleConfig struct {
+	Pattern   string            `json:"pattern" yaml:"pattern"`
+	Condition *condition.Config `json:"condition" yaml:"condition"`
+	Action    string            `json:"action" yaml:"action"`
+}
+
+// NewErrorRuleConfig creates a new ErrorRuleConfig with default values.
+func NewErrorRuleConfig() ErrorRuleConfig {
+	return ErrorRuleConfig{
+		Pattern:   "",
+		Condition: nil,
+		Action:    string(ErrorActionRetry),
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// errorRule is the compiled form of an ErrorRuleConfig.
+type errorRule struct {
+	pattern   *regexp.Regexp
+	condition types.Condition
+	action    ErrorAction
+}
+
+// errorPolicy classifies a failed send into an ErrorAction by walking a list
+// of compiled rules in order, falling back to ErrorActionRetry when nothing
+// matches.
+type errorPolicy struct {
+	rules []errorRule
+}
+
+func newErrorPolicy(
+	confs []ErrorRuleConfig,
+	mgr types.Manager,
+	log log.Modular,
+	stats metrics.Type,
+) (*errorPolicy, error) {
+	rules := make([]errorRule, len(confs))
+	for i, c := range confs {
+		switch ErrorAction(c.Action) {
+		case ErrorActionRetry, ErrorActionDrop, ErrorActionAck, ErrorActionDeadLetter:
+			rules[i].action = ErrorAction(c.Action)
+		default:
+			return nil, fmt.Errorf("error_policy[%v]: unrecognised action '%v'", i, c.Action)
+		}
+
+		if c.Pattern != "" {
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("error_policy[%v]: failed to compile pattern: %v", i, err)
+			}
+			rules[i].pattern = re
+		}
+
+		if c.Condition != nil {
+			cond, err := condition.New(*c.Condition, mgr, log.NewModule(fmt.Sprintf(".error_policy.%v", i)), metrics.Namespaced(stats, fmt.Sprintf("error_policy.%v", i)))
+			if err != nil {
+				return nil, fmt.Errorf("error_policy[%v]: failed to create condition: %v", i, err)
+			}
+			rules[i].condition = cond
+		}
+	}
+	return &errorPolicy{rules: rules}, nil
+}
+
+// classify returns the action to take for a send of msg that failed with err.
+func (p *errorPolicy) classify(err error, msg types.Message) ErrorAction {
+	for _, rule := range p.rules {
+		if rule.pattern != nil && !rule.pattern.MatchString(err.Error()) {
+			continue
+		}
+		if rule.condition != nil && !rule.condition.Check(msg) {
+			continue
+		}
+		return rule.action
+	}
+	return ErrorActionRetry
+}
+
+//------------------------------------------------------------------------------
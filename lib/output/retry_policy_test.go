@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+func TestErrorPolicyClassify(t *testing.T) {
+	rules := []ErrorRuleConfig{
+		{Pattern: "permission denied", Action: string(ErrorActionDrop)},
+		{Pattern: "rejected: .*", Action: string(ErrorActionAck)},
+		{Pattern: "", Action: string(ErrorActionDeadLetter)},
+	}
+
+	policy, err := newErrorPolicy(rules, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatalf("unexpected error constructing policy: %v", err)
+	}
+
+	payload := message.New([][]byte{[]byte("hello")})
+
+	tests := []struct {
+		name   string
+		err    error
+		action ErrorAction
+	}{
+		{"matches first rule", errors.New("permission denied for topic"), ErrorActionDrop},
+		{"matches second rule", errors.New("rejected: bad schema"), ErrorActionAck},
+		{"falls through to catch-all", errors.New("connection reset"), ErrorActionDeadLetter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.classify(tt.err, payload); got != tt.action {
+				t.Errorf("classify(%q) = %v, want %v", tt.err, got, tt.action)
+			}
+		})
+	}
+}
+
+func TestErrorPolicyClassifyNoRulesRetries(t *testing.T) {
+	policy, err := newErrorPolicy(nil, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatalf("unexpected error constructing policy: %v", err)
+	}
+	payload := message.New([][]byte{[]byte("hello")})
+	if got := policy.classify(errors.New("anything"), payload); got != ErrorActionRetry {
+		t.Errorf("classify with no rules = %v, want %v", got, ErrorActionRetry)
+	}
+}
+
+func TestErrorPolicyRejectsUnrecognisedAction(t *testing.T) {
+	rules := []ErrorRuleConfig{{Pattern: "", Action: "explode"}}
+	if _, err := newErrorPolicy(rules, nil, log.Noop(), metrics.Noop()); err == nil {
+		t.Fatal("expected an error for an unrecognised action")
+	}
+}
+
+func TestErrorPolicyRejectsBadPattern(t *testing.T) {
+	rules := []ErrorRuleConfig{{Pattern: "(", Action: string(ErrorActionRetry)}}
+	if _, err := newErrorPolicy(rules, nil, log.Noop(), metrics.Noop()); err == nil {
+		t.Fatal("expected an error for an invalid regular expression")
+	}
+}